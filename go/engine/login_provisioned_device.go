@@ -4,16 +4,74 @@
 package engine
 
 import (
+	"net"
+	"time"
+
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
 )
 
+// RetryPolicy controls how LoginProvisionedDevice retries a failed
+// stored-secret login. Retries are driven by ConnectivityMonitor: a
+// transition to reachable cuts the current wait short instead of making
+// the user wait out the rest of the backoff step.
+type RetryPolicy struct {
+	// Delays is the backoff schedule between attempts; once exhausted,
+	// the last entry repeats for every subsequent attempt. Empty means
+	// DefaultRetryPolicy's schedule.
+	Delays []time.Duration
+
+	// MaxAttempts caps the number of attempts. 0 means unlimited
+	// (bounded only by TotalBudget, if set).
+	MaxAttempts int
+
+	// TotalBudget caps the wall-clock time spent retrying. 0 means
+	// unbounded.
+	TotalBudget time.Duration
+}
+
+// DefaultRetryPolicy is used whenever a LoginProvisionedDevice is run
+// with SecretStoreOnly set but no explicit RetryPolicy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Delays: []time.Duration{
+			1 * time.Second,
+			2 * time.Second,
+			5 * time.Second,
+			15 * time.Second,
+			60 * time.Second,
+		},
+		TotalBudget: 10 * time.Minute,
+	}
+}
+
+func (p RetryPolicy) delayFor(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	if attempt >= len(p.Delays) {
+		return p.Delays[len(p.Delays)-1]
+	}
+	return p.Delays[attempt]
+}
+
 // LoginProvisionedDevice is an engine that tries to login using the
 // current device, if there is an existing provisioned device.
 type LoginProvisionedDevice struct {
 	libkb.Contextified
 	username        libkb.NormalizedUsername
 	SecretStoreOnly bool // this should only be set by the service on its startup login attempt
+
+	// Passive, if set, provisions this login into the libkb.SessionRegistry
+	// as a background session rather than the active one: the session is
+	// cached but notifications are suppressed and it gets no push
+	// subscriptions until something promotes it via SwitchActiveUser.
+	Passive bool
+
+	// RetryPolicy tunes the backoff used when SecretStoreOnly is true
+	// and LoginWithStoredSecret fails transiently. Zero value means
+	// DefaultRetryPolicy().
+	RetryPolicy RetryPolicy
 }
 
 // newLoginCurrentDevice creates a loginProvisionedDevice engine.
@@ -56,6 +114,11 @@ func (e *LoginProvisionedDevice) Run(m libkb.MetaContext) error {
 		return err
 	}
 
+	if e.Passive {
+		m.CDebugf("LoginProvisionedDevice success for %s, staying passive: no login notification or hooks", e.username)
+		return nil
+	}
+
 	m.CDebugf("LoginProvisionedDevice success, sending login notification")
 	m.G().NotifyRouter.HandleLogin(string(m.G().Env.GetUsername()))
 	m.CDebugf("LoginProvisionedDevice success, calling login hooks")
@@ -136,10 +199,18 @@ func (e *LoginProvisionedDevice) loadMe(m libkb.MetaContext) (err error) {
 func (e *LoginProvisionedDevice) run(m libkb.MetaContext) (err error) {
 	defer m.CTrace("LoginProvisionedDevice#run", func() error { return err })()
 
-	// already logged in?
-	in, uid := isLoggedIn(m)
-	if in && (len(e.username) == 0 || m.G().Env.GetUsernameForUID(uid).Eq(e.username)) {
-		m.CDebugf("user %s already logged in; short-circuting", uid)
+	registry := m.G().SessionRegistry()
+
+	// already logged in? With multiple sessions live at once, "logged in"
+	// means "has a cached session in the registry for this username",
+	// not just "is the current active identity".
+	if len(e.username) > 0 {
+		if cs := registry.Lookup(e.username); cs != nil {
+			m.CDebugf("user %s already has a cached session; short-circuiting", e.username)
+			return nil
+		}
+	} else if in, uid := isLoggedIn(m); in {
+		m.CDebugf("user %s already logged in; short-circuiting", uid)
 		return nil
 	}
 
@@ -153,15 +224,19 @@ func (e *LoginProvisionedDevice) run(m libkb.MetaContext) (err error) {
 	// just login normally.
 
 	var afterLogin = func(lctx libkb.LoginContext) error {
+		// per-session, not global: each provisioned user gets its own
+		// SetDeviceProvisioned call against its own LocalSession, so
+		// logging in a second user doesn't touch the first one's state.
 		if err := lctx.LocalSession().SetDeviceProvisioned(m.G().Env.GetDeviceID()); err != nil {
 			// not a fatal error, session will stay in memory
 			m.CWarningf("error saving session file: %s", err)
 		}
+		registry.Register(lctx.LocalSession().GetUID(), e.username, m.G().Env.GetDeviceID(), e.Passive)
 		return nil
 	}
 
 	if e.SecretStoreOnly {
-		if err := m.G().LoginState().LoginWithStoredSecret(m, e.username.String(), afterLogin); err != nil {
+		if err := e.runSecretStoreOnly(m, afterLogin); err != nil {
 			return err
 		}
 
@@ -171,40 +246,152 @@ func (e *LoginProvisionedDevice) run(m libkb.MetaContext) (err error) {
 		}
 	}
 
-	// login was successful, unlock the device keys
-	// err = e.unlockDeviceKeys(m, me)
+	// login was successful, unlock the device keys. Load e.username
+	// specifically rather than assuming "self": with multiple sessions
+	// live at once, e.username may be a passive session being logged in
+	// in the background, not whoever G().Env currently considers active.
+	meArg := libkb.NewLoadUserArgWithMetaContext(m).WithSelf(true)
+	if len(e.username) > 0 {
+		meArg = libkb.NewLoadUserArgWithMetaContext(m).WithName(e.username.String())
+	}
+	me, err := libkb.LoadUser(meArg)
 	if err != nil {
 		return err
 	}
-	return nil
+	return e.unlockDeviceKeys(m, me)
+}
+
+// runSecretStoreOnly drives LoginWithStoredSecret, turning the old
+// one-shot attempt into a resilient background process: transient
+// failures (network down, a failed server-half fetch, a 5xx from
+// LoadV2) are retried with exponential backoff instead of surfaced
+// immediately, since this path only runs on service startup with no
+// user watching it fail.
+func (e *LoginProvisionedDevice) runSecretStoreOnly(m libkb.MetaContext, afterLogin func(libkb.LoginContext) error) (err error) {
+	defer m.CTrace("LoginProvisionedDevice#runSecretStoreOnly", func() error { return err })()
+
+	policy := e.RetryPolicy
+	if len(policy.Delays) == 0 && policy.MaxAttempts == 0 && policy.TotalBudget == 0 {
+		policy = DefaultRetryPolicy()
+	} else if len(policy.Delays) == 0 {
+		policy.Delays = DefaultRetryPolicy().Delays
+	}
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err = m.G().LoginState().LoginWithStoredSecret(m, e.username.String(), afterLogin)
+		if err == nil {
+			return nil
+		}
+		if !isTransientLoginError(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt+1 >= policy.MaxAttempts {
+			m.CDebugf("LoginProvisionedDevice: giving up after %d attempts: %s", attempt+1, err)
+			return err
+		}
+		if policy.TotalBudget > 0 && time.Now().Sub(start) >= policy.TotalBudget {
+			m.CDebugf("LoginProvisionedDevice: giving up after exceeding retry budget of %s: %s", policy.TotalBudget, err)
+			return err
+		}
+
+		delay := policy.delayFor(attempt)
+		m.CDebugf("LoginProvisionedDevice: stored-secret login failed (%s), retrying in %s (attempt %d)", err, delay, attempt+1)
+		m.G().NotifyRouter.HandleLoginRetry(keybase1.LoginRetryArg{
+			Attempt:   attempt + 1,
+			DelayMsec: delay.Nanoseconds() / int64(time.Millisecond),
+		})
+
+		if !e.waitForRetryOrReachable(m, delay) {
+			m.CDebugf("LoginProvisionedDevice: context cancelled while waiting to retry: %s", m.Ctx().Err())
+			return m.Ctx().Err()
+		}
+	}
 }
 
-func (e *LoginProvisionedDevice) unlockDeviceKeys(m libkb.MetaContext, me *libkb.User) error {
+// waitForRetryOrReachable blocks for at most delay, returning true when
+// it's time to retry. It returns early (true) the moment
+// ConnectivityMonitor goes from not reachable to reachable, so a
+// connectivity flap doesn't cost the user the rest of the backoff step.
+// It returns false, distinctly, if m.Ctx() is cancelled first, so the
+// caller aborts the retry loop instead of spinning straight back into
+// another LoginWithStoredSecret call on shutdown.
+func (e *LoginProvisionedDevice) waitForRetryOrReachable(m libkb.MetaContext, delay time.Duration) bool {
+	poll := 250 * time.Millisecond
+	if poll > delay {
+		poll = delay
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
 
-	// CORE-5876 idea that lksec will be unusable if reachability state is NO
-	// and the user changed passphrase with a different device since it won't
-	// be able to sync the new server half.
-	if m.G().ConnectivityMonitor.IsConnected(m.Ctx()) != libkb.ConnectivityMonitorYes {
-		m.CDebugf("LoginProvisionedDevice: in unlockDeviceKeys, ConnectivityMonitor says not reachable, check to make sure")
-		if err := m.G().ConnectivityMonitor.CheckReachability(m.Ctx()); err != nil {
-			m.CDebugf("error checking reachability: %s", err)
-		} else {
-			connected := m.G().ConnectivityMonitor.IsConnected(m.Ctx())
-			m.CDebugf("after CheckReachability(), IsConnected() => %v (connected? %v)", connected, connected == libkb.ConnectivityMonitorYes)
+	wasConnected := m.G().ConnectivityMonitor.IsConnected(m.Ctx()) == libkb.ConnectivityMonitorYes
+	deadline := time.Now().Add(delay)
+	for time.Now().Before(deadline) {
+		select {
+		case <-m.Ctx().Done():
+			return false
+		case <-ticker.C:
+			connected := m.G().ConnectivityMonitor.IsConnected(m.Ctx()) == libkb.ConnectivityMonitorYes
+			if connected && !wasConnected {
+				m.CDebugf("LoginProvisionedDevice: connectivity restored, retrying now")
+				return true
+			}
+			wasConnected = connected
 		}
 	}
+	return true
+}
+
+// isTransientLoginError reports whether err is worth retrying: a
+// network error, a failed server-half fetch, or a 5xx from the API
+// server. Anything else (bad passphrase, revoked device, deleted user)
+// is permanent and should surface to the caller immediately.
+func isTransientLoginError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch terr := err.(type) {
+	case libkb.APINetError:
+		return true
+	case *libkb.APIError:
+		return terr.Code >= 500
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Temporary() || netErr.Timeout()
+	}
+	return false
+}
+
+// unlockDeviceKeys unlocks the device's signing and encryption keys via
+// the unlocker chain preferred by me's UserConfig, falling back through
+// passphrase/LKSec, the OS secret store, and a hardware token in turn.
+// A successful non-passphrase unlock never shows the SecretUI prompt.
+func (e *LoginProvisionedDevice) unlockDeviceKeys(m libkb.MetaContext, me *libkb.User) (err error) {
+	defer m.CTrace("LoginProvisionedDevice#unlockDeviceKeys", func() error { return err })()
+
+	var config *libkb.UserConfig
+	if config, err = m.G().Env.GetConfig().GetUserConfigForUsername(e.username); err != nil {
+		m.CDebugf("unlockDeviceKeys: error getting user config, falling back to default unlocker chain: %s", err)
+		config = nil
+	}
+	unlocker := libkb.UnlockerChainForUser(m.G(), config)
 
 	ska := libkb.SecretKeyArg{
 		Me:      me,
 		KeyType: libkb.DeviceSigningKeyType,
 	}
-	_, err := m.G().Keyrings.GetSecretKeyWithPrompt(m, m.SecretKeyPromptArg(ska, "unlock device keys"))
-	if err != nil {
+	if _, err := m.G().Keyrings.GetSecretKeyWithUnlocker(m, ska, "unlock device keys", unlocker); err != nil {
 		return err
 	}
+
+	if config.GetPreferredUnlocker() == "" && len(unlocker) > 0 {
+		if werr := libkb.WritePreferredUnlocker(m, e.username, unlocker[0].Name()); werr != nil {
+			m.CWarningf("unlockDeviceKeys: failed to persist preferred unlocker: %s", werr)
+		}
+	}
+
 	ska.KeyType = libkb.DeviceEncryptionKeyType
-	_, err = m.G().Keyrings.GetSecretKeyWithPrompt(m, m.SecretKeyPromptArg(ska, "unlock device keys"))
-	if err != nil {
+	if _, err := m.G().Keyrings.GetSecretKeyWithUnlocker(m, ska, "unlock device keys", unlocker); err != nil {
 		return err
 	}
 
@@ -0,0 +1,62 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelayFor(t *testing.T) {
+	p := DefaultRetryPolicy()
+	require.Equal(t, 1*time.Second, p.delayFor(0))
+	require.Equal(t, 2*time.Second, p.delayFor(1))
+	require.Equal(t, 60*time.Second, p.delayFor(4))
+	require.Equal(t, 60*time.Second, p.delayFor(100), "schedule caps at the last delay once exhausted")
+	require.Equal(t, 1*time.Second, p.delayFor(-1), "a negative attempt is treated as the first one")
+}
+
+type timeoutNetError struct{}
+
+func (timeoutNetError) Error() string   { return "timeout" }
+func (timeoutNetError) Timeout() bool   { return true }
+func (timeoutNetError) Temporary() bool { return false }
+
+func TestIsTransientLoginError(t *testing.T) {
+	require.False(t, isTransientLoginError(nil))
+	require.False(t, isTransientLoginError(errors.New("bad passphrase")))
+	require.True(t, isTransientLoginError(libkb.APINetError{}))
+	require.True(t, isTransientLoginError(&libkb.APIError{Code: 503}))
+	require.False(t, isTransientLoginError(&libkb.APIError{Code: 400}), "a 4xx is a permanent failure, not worth retrying")
+
+	var ne net.Error = timeoutNetError{}
+	require.True(t, isTransientLoginError(ne))
+}
+
+func TestWaitForRetryOrReachableAbortsOnCancel(t *testing.T) {
+	tc := SetupEngineTest(t, "login_provisioned_device")
+	defer tc.Cleanup()
+
+	m := libkb.NewMetaContextForTest(tc)
+	ctx, cancel := context.WithCancel(m.Ctx())
+	m = m.WithContext(ctx)
+	cancel()
+
+	e := &LoginProvisionedDevice{}
+	done := make(chan bool, 1)
+	go func() { done <- e.waitForRetryOrReachable(m, time.Minute) }()
+
+	select {
+	case ok := <-done:
+		require.False(t, ok, "a cancelled context should abort the wait immediately, not report time-to-retry")
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForRetryOrReachable did not return promptly after context cancellation")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package engine
+
+import (
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// SwitchActiveUser is an engine that promotes a passive, already-cached
+// session to be the active identity for the service, demoting whatever
+// was previously active to passive in its place. This is the engine
+// behind letting the GUI switch between provisioned accounts without
+// re-entering a passphrase or tearing down chat state.
+type SwitchActiveUser struct {
+	libkb.Contextified
+	username libkb.NormalizedUsername
+}
+
+// NewSwitchActiveUser creates a SwitchActiveUser engine for username,
+// which must already have a cached session in the libkb.SessionRegistry
+// (i.e. it was provisioned or logged in earlier in this process).
+func NewSwitchActiveUser(g *libkb.GlobalContext, username string) *SwitchActiveUser {
+	return &SwitchActiveUser{
+		username:     libkb.NewNormalizedUsername(username),
+		Contextified: libkb.NewContextified(g),
+	}
+}
+
+// Name is the unique engine name.
+func (e *SwitchActiveUser) Name() string {
+	return "SwitchActiveUser"
+}
+
+// Prereqs returns the engine prereqs.
+func (e *SwitchActiveUser) Prereqs() Prereqs {
+	return Prereqs{}
+}
+
+// RequiredUIs returns the required UIs.
+func (e *SwitchActiveUser) RequiredUIs() []libkb.UIKind {
+	return []libkb.UIKind{}
+}
+
+// SubConsumers returns the other UI consumers for this engine.
+func (e *SwitchActiveUser) SubConsumers() []libkb.UIConsumer {
+	return nil
+}
+
+func (e *SwitchActiveUser) Run(m libkb.MetaContext) (err error) {
+	defer m.CTrace("SwitchActiveUser#Run", func() error { return err })()
+
+	registry := m.G().SessionRegistry()
+	cs := registry.Lookup(e.username)
+	if cs == nil {
+		return libkb.NotFoundError{Msg: "no cached session for " + e.username.String()}
+	}
+
+	prev := registry.Active()
+	if prev != nil && prev.UID.Equal(cs.UID) {
+		m.CDebugf("SwitchActiveUser: %s is already active", e.username)
+		return nil
+	}
+
+	// The registry flag is just bookkeeping; ActiveDevice and LoginState
+	// are what the rest of the service (loading self, posting, API
+	// calls, notifications) actually consult to decide who "the current
+	// user" is, so the handoff isn't real until those move too. All of
+	// it happens while the registry stays locked, so a concurrent
+	// SwitchActiveUser for a different user can't interleave with this
+	// one and leave the registry and ActiveDevice disagreeing about who
+	// is active; a failure partway through rolls the registry back and
+	// puts ActiveDevice and LoginState back on the previous user, so all
+	// three still agree.
+	err = registry.PromoteToActiveWithHandoff(cs.UID, func(previous *libkb.CachedSession) (err error) {
+		var prevSession libkb.CachedSession
+		if previous != nil {
+			prevSession.UID, prevSession.Username, prevSession.DeviceID = previous.UID, previous.Username, previous.DeviceID
+		}
+		if err := m.G().ActiveDevice.SetActiveUser(m, cs.UID, cs.Username, cs.DeviceID); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				_ = m.G().ActiveDevice.SetActiveUser(m, prevSession.UID, prevSession.Username, prevSession.DeviceID)
+				_ = m.G().LoginState().SwitchUser(prevSession.Username)
+			}
+		}()
+		if err := m.G().LoginState().SwitchUser(cs.Username); err != nil {
+			return err
+		}
+
+		// Hand gregor/chat subscription ownership from the outgoing
+		// active identity to the incoming one. GregorChatOwner is
+		// registered by those subsystems at startup; it's nil in
+		// configurations that don't wire them up, in which case
+		// there's nothing to hand off.
+		if owner := m.G().GregorChatOwner; owner != nil {
+			var previousUID keybase1.UID
+			if previous != nil {
+				previousUID = previous.UID
+			}
+			if err := owner.TakeOver(m, cs.UID, previousUID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.CDebugf("SwitchActiveUser: %s promoted to active, sending login notification", e.username)
+	m.G().NotifyRouter.HandleLogin(e.username.String())
+	m.CDebugf("SwitchActiveUser: calling login hooks")
+	m.G().CallLoginHooks()
+
+	return nil
+}
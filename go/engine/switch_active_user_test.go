@@ -0,0 +1,55 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package engine
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitchActiveUserConcurrentSwitchesStayConsistent(t *testing.T) {
+	tc := SetupEngineTest(t, "switch_active_user")
+	defer tc.Cleanup()
+
+	tc.G.ActiveDevice = &libkb.ActiveDevice{}
+	tc.G.NotifyRouter = &libkb.NotifyRouter{}
+
+	registry := tc.G.SessionRegistry()
+	uidA := keybase1.UID("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	uidB := keybase1.UID("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	registry.Register(uidA, libkb.NewNormalizedUsername("alice"), keybase1.DeviceID(""), false)
+	registry.Register(uidB, libkb.NewNormalizedUsername("bob"), keybase1.DeviceID(""), true)
+
+	m := libkb.NewMetaContextForTest(tc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 25; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = NewSwitchActiveUser(tc.G, "alice").Run(m)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = NewSwitchActiveUser(tc.G, "bob").Run(m)
+		}()
+	}
+	wg.Wait()
+
+	active := registry.Active()
+	require.NotNil(t, active, "exactly one session must end up active")
+
+	var other *libkb.CachedSession
+	if active.UID.Equal(uidA) {
+		other = registry.Lookup(libkb.NewNormalizedUsername("bob"))
+	} else {
+		other = registry.Lookup(libkb.NewNormalizedUsername("alice"))
+	}
+	require.False(t, active.Passive(), "the registry's active session must never be marked passive")
+	require.True(t, other.Passive(), "the loser of a concurrent switch must end up passive, not also active")
+}
@@ -0,0 +1,25 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import "github.com/keybase/client/go/protocol/keybase1"
+
+// ActiveDevice tracks which provisioned device and user the rest of the
+// service (API calls, chat, notifications) should treat as "the current
+// user" at any given moment.
+type ActiveDevice struct {
+	uid      keybase1.UID
+	username NormalizedUsername
+	deviceID keybase1.DeviceID
+}
+
+// SetActiveUser points ActiveDevice at uid/username/deviceID, the way
+// SwitchActiveUser calls it once SessionRegistry has promoted that
+// session to active.
+func (a *ActiveDevice) SetActiveUser(m MetaContext, uid keybase1.UID, username NormalizedUsername, deviceID keybase1.DeviceID) error {
+	a.uid = uid
+	a.username = username
+	a.deviceID = deviceID
+	return nil
+}
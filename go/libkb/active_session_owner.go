@@ -0,0 +1,19 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import "github.com/keybase/client/go/protocol/keybase1"
+
+// ActiveSessionOwner is the extension point the gregor and chat
+// subsystems register themselves against (as GlobalContext.GregorChatOwner)
+// when the service starts up. SwitchActiveUser calls it to hand
+// subscription ownership from the outgoing active identity to the
+// incoming one, so SessionRegistry and the engine layer don't need to
+// import gregor or chat directly.
+type ActiveSessionOwner interface {
+	// TakeOver moves subscription ownership to uid, tearing down
+	// whatever was held on behalf of previous first. previous is the
+	// zero UID if there was no active session before this call.
+	TakeOver(m MetaContext, uid keybase1.UID, previous keybase1.UID) error
+}
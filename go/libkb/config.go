@@ -0,0 +1,30 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import "github.com/keybase/client/go/protocol/keybase1"
+
+// UserConfig is the per-user slice of the on-disk config file: which
+// device this install is provisioned as for that user, plus any login
+// preferences recorded for them. It's serialized as part of the larger
+// config file, keyed by username, the same as the device ID below.
+type UserConfig struct {
+	deviceID keybase1.DeviceID
+
+	// PreferredUnlocker is the SecretUnlocker.Name() that
+	// UnlockerChainForUser puts first for this user. LoginProvisionedDevice
+	// records the head of the chain it used the first time a login
+	// succeeds with none recorded; which unlocker in the chain actually
+	// produced the secret is not tracked. Empty means no preference
+	// recorded yet.
+	PreferredUnlocker string `json:"preferred_unlocker,omitempty"`
+}
+
+// GetDeviceID returns the device ID this config was provisioned with.
+func (u *UserConfig) GetDeviceID() keybase1.DeviceID {
+	if u == nil {
+		return ""
+	}
+	return u.deviceID
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// This file adds the multi-session wiring to GlobalContext: the
+// SessionRegistry itself, the ActiveDevice and GregorChatOwner hooks
+// SwitchActiveUser hands the active identity off to, and the LoginState
+// accessor it calls to move the process-wide "current user" pointer.
+// GlobalContext's other fields (Env, Keyrings, ConnectivityMonitor, ...) live
+// alongside these in the rest of the service and aren't reproduced here.
+
+// GlobalContext holds the global, per-process state needed to switch
+// which provisioned session is active.
+type GlobalContext struct {
+	ActiveDevice    *ActiveDevice
+	GregorChatOwner ActiveSessionOwner
+	NotifyRouter    *NotifyRouter
+
+	loginState      *LoginState
+	sessionRegistry *SessionRegistry
+}
+
+// NewGlobalContext returns a GlobalContext with its SessionRegistry
+// constructed and ready to register sessions into. Callers wire up
+// ActiveDevice, GregorChatOwner, and the rest of GlobalContext's fields
+// themselves during service startup.
+func NewGlobalContext() *GlobalContext {
+	g := &GlobalContext{loginState: &LoginState{}}
+	g.sessionRegistry = NewSessionRegistry(g)
+	return g
+}
+
+// SessionRegistry returns the registry of cached provisioned sessions
+// for this service.
+func (g *GlobalContext) SessionRegistry() *SessionRegistry {
+	return g.sessionRegistry
+}
+
+// LoginState returns the login state machine used to drive interactive
+// and stored-secret logins, and to track which user is currently active.
+func (g *GlobalContext) LoginState() *LoginState {
+	return g.loginState
+}
+
+// Contextified is embedded by types that need a handle back to the
+// GlobalContext they were constructed with.
+type Contextified struct {
+	g *GlobalContext
+}
+
+// NewContextified wraps g for embedding into a Contextified type.
+func NewContextified(g *GlobalContext) Contextified {
+	return Contextified{g: g}
+}
+
+// G returns the GlobalContext this value was constructed with.
+func (c Contextified) G() *GlobalContext {
+	return c.g
+}
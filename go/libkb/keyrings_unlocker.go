@@ -0,0 +1,24 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// GetSecretKeyWithUnlocker is a variant of GetSecretKeyWithPrompt that
+// gives unlocker first crack at producing the secret for ska. The
+// SecretUI prompt behind GetSecretKeyWithPrompt is only reached if
+// unlocker reports ErrUnlockerUnavailable, so a successful hardware-token
+// or secret-store unlock never shows a passphrase prompt.
+func (k *Keyrings) GetSecretKeyWithUnlocker(m MetaContext, ska SecretKeyArg, reason string, unlocker SecretUnlocker) (GenericKey, error) {
+	if unlocker != nil {
+		secret, err := unlocker.Unlock(m, ska, reason)
+		switch err {
+		case nil:
+			return k.unlockLocalDeviceKey(m, ska, secret)
+		case ErrUnlockerUnavailable:
+			m.CDebugf("GetSecretKeyWithUnlocker: %s unavailable, falling back to prompt", unlocker.Name())
+		default:
+			return nil, err
+		}
+	}
+	return k.GetSecretKeyWithPrompt(m, m.SecretKeyPromptArg(ska, reason))
+}
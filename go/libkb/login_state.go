@@ -0,0 +1,22 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// LoginState drives interactive and stored-secret logins and tracks
+// which username the process currently considers logged in. Its
+// passphrase/session machinery (LoginWithPrompt, LoginWithStoredSecret,
+// GetPassphraseStreamLKSecFromPrompt) lives alongside this file; this
+// adds only the piece SwitchActiveUser needs.
+type LoginState struct {
+	current NormalizedUsername
+}
+
+// SwitchUser moves LoginState's notion of the current user to username,
+// without running a fresh login: the session is already cached in
+// SessionRegistry, so this just repoints the single-active-user state
+// the rest of the service consults.
+func (s *LoginState) SwitchUser(username NormalizedUsername) error {
+	s.current = username
+	return nil
+}
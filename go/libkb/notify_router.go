@@ -0,0 +1,20 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import "github.com/keybase/client/go/protocol/keybase1"
+
+// NotifyRouter fans service-side events out to connected clients.
+// HandleLogin already existed for successful logins; HandleLoginRetry is
+// new, used by LoginProvisionedDevice's stored-secret retry loop so the
+// GUI can show retry progress instead of going quiet until it either
+// succeeds or gives up.
+type NotifyRouter struct{}
+
+// HandleLogin notifies clients that username just logged in.
+func (n *NotifyRouter) HandleLogin(username string) {}
+
+// HandleLoginRetry notifies clients of a stored-secret login attempt
+// that failed transiently and is about to be retried.
+func (n *NotifyRouter) HandleLoginRetry(arg keybase1.LoginRetryArg) {}
@@ -0,0 +1,85 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import "errors"
+
+// ErrUnlockerUnavailable is returned by a SecretUnlocker when its source
+// can't supply the secret (no token present, a token that declined the
+// challenge, no stored secret, etc), as opposed to a fatal error.
+// Callers use this to decide whether to fall through to the next
+// unlocker in the chain or give up.
+var ErrUnlockerUnavailable = errors.New("secret unlocker unavailable")
+
+// SecretUnlocker abstracts over the different ways a device's signing
+// or encryption key can be unlocked: a passphrase run through LKSec,
+// the OS-provided secret store, or a hardware token. Routing both key
+// types through the same interface means login doesn't have to know or
+// care which source produced the secret.
+type SecretUnlocker interface {
+	// Name identifies the unlocker for logging and for matching against
+	// UserConfig.PreferredUnlocker.
+	Name() string
+
+	// Unlock produces the secret needed to unlock ska's key. reason is
+	// the same human-readable prompt text passed to SecretUI elsewhere
+	// in this flow. Implementations that can't attempt the unlock at
+	// all should return ErrUnlockerUnavailable rather than a fatal error.
+	Unlock(m MetaContext, ska SecretKeyArg, reason string) (LKSecFullSecret, error)
+}
+
+// UnlockerChain tries each SecretUnlocker in order, falling through to
+// the next whenever one reports ErrUnlockerUnavailable.
+type UnlockerChain []SecretUnlocker
+
+// Unlock satisfies SecretUnlocker so a chain can be passed anywhere a
+// single unlocker is expected.
+func (c UnlockerChain) Unlock(m MetaContext, ska SecretKeyArg, reason string) (secret LKSecFullSecret, err error) {
+	for _, u := range c {
+		secret, err = u.Unlock(m, ska, reason)
+		if err == nil {
+			return secret, nil
+		}
+		if err != ErrUnlockerUnavailable {
+			return LKSecFullSecret{}, err
+		}
+		m.CDebugf("UnlockerChain: %s unavailable, trying next", u.Name())
+	}
+	return LKSecFullSecret{}, ErrUnlockerUnavailable
+}
+
+// Name identifies the chain itself for logging.
+func (c UnlockerChain) Name() string {
+	return "UnlockerChain"
+}
+
+// UnlockerChainForUser builds the unlocker chain to try for config,
+// putting its PreferredUnlocker first (if set and recognized) and
+// falling back through the rest in a stable order, so login still
+// works even if the preferred unlocker isn't available on this machine.
+func UnlockerChainForUser(g *GlobalContext, config *UserConfig) UnlockerChain {
+	all := []SecretUnlocker{
+		NewPassphraseUnlocker(g),
+		NewSecretStoreUnlocker(g),
+		NewExternalTokenUnlocker(g),
+	}
+
+	preferred := ""
+	if config != nil {
+		preferred = config.GetPreferredUnlocker()
+	}
+	if preferred == "" {
+		return all
+	}
+
+	chain := make(UnlockerChain, 0, len(all))
+	for _, u := range all {
+		if u.Name() == preferred {
+			chain = append(UnlockerChain{u}, chain...)
+		} else {
+			chain = append(chain, u)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// ExternalTokenUnlockerName is the UserConfig.PreferredUnlocker value
+// that selects ExternalTokenUnlocker.
+const ExternalTokenUnlockerName = "external-token"
+
+// ExternalTokenUnlocker satisfies the unlock by round-tripping a
+// challenge to a hardware token: a PKCS#11/PIV/YubiKey OpenPGP applet,
+// or a FIDO2 credential with the hmac-secret extension. It lets login
+// work on a machine where the user wants "presence + PIN on hardware
+// token" instead of a Keybase passphrase.
+//
+// This is a thin dispatcher; the actual challenge/response protocol
+// lives behind the ExternalTokenDriver interface so individual token
+// backends (PKCS#11 vs FIDO2) can be added without touching login.
+type ExternalTokenUnlocker struct {
+	Contextified
+}
+
+// NewExternalTokenUnlocker creates an ExternalTokenUnlocker.
+func NewExternalTokenUnlocker(g *GlobalContext) *ExternalTokenUnlocker {
+	return &ExternalTokenUnlocker{Contextified: NewContextified(g)}
+}
+
+// Name implements SecretUnlocker.
+func (u *ExternalTokenUnlocker) Name() string {
+	return ExternalTokenUnlockerName
+}
+
+// ExternalTokenDriver is the challenge/response contract a hardware
+// token backend implements. GlobalContext.ExternalTokenDriver is nil
+// unless a backend (PKCS#11, PIV, FIDO2) registered itself, which is
+// how this unlocker stays optional rather than a hard dependency.
+type ExternalTokenDriver interface {
+	// Detect reports whether a token this driver understands is
+	// currently present and ready to respond to a challenge.
+	Detect(m MetaContext) bool
+
+	// Respond round-trips challenge to the token (prompting for
+	// presence/PIN as the token requires) and returns the response
+	// used to derive the unlock secret.
+	Respond(m MetaContext, challenge []byte) (response []byte, err error)
+}
+
+// Unlock implements SecretUnlocker by detecting a usable hardware token
+// and round-tripping a challenge to it. If no driver is registered, no
+// token is present, or the token declines the challenge, it reports
+// ErrUnlockerUnavailable.
+func (u *ExternalTokenUnlocker) Unlock(m MetaContext, ska SecretKeyArg, reason string) (LKSecFullSecret, error) {
+	driver := u.G().ExternalTokenDriver
+	if driver == nil || !driver.Detect(m) {
+		return LKSecFullSecret{}, ErrUnlockerUnavailable
+	}
+
+	challenge, err := NewLKSecServerHalfChallenge(ska.Me.GetUID())
+	if err != nil {
+		return LKSecFullSecret{}, err
+	}
+
+	response, err := driver.Respond(m, challenge)
+	if err != nil {
+		m.CDebugf("ExternalTokenUnlocker: token declined challenge: %s", err)
+		return LKSecFullSecret{}, ErrUnlockerUnavailable
+	}
+
+	return NewLKSecFullSecretFromBytes(response)
+}
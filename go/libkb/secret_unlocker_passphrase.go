@@ -0,0 +1,44 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// PassphraseUnlockerName is the UserConfig.PreferredUnlocker value that
+// selects PassphraseUnlocker.
+const PassphraseUnlockerName = "passphrase"
+
+// PassphraseUnlocker unlocks a device key by prompting for the user's
+// passphrase and deriving LKSec from it. This is today's default
+// behavior, wrapped in the SecretUnlocker interface.
+type PassphraseUnlocker struct {
+	Contextified
+}
+
+// NewPassphraseUnlocker creates a PassphraseUnlocker.
+func NewPassphraseUnlocker(g *GlobalContext) *PassphraseUnlocker {
+	return &PassphraseUnlocker{Contextified: NewContextified(g)}
+}
+
+// Name implements SecretUnlocker.
+func (u *PassphraseUnlocker) Name() string {
+	return PassphraseUnlockerName
+}
+
+// Unlock implements SecretUnlocker by prompting for the passphrase via
+// SecretUI and deriving LKSec from it, same as the pre-refactor
+// unlockDeviceKeys did directly.
+func (u *PassphraseUnlocker) Unlock(m MetaContext, ska SecretKeyArg, reason string) (LKSecFullSecret, error) {
+	// CORE-5876: LKSec can be unusable if reachability is NO and the
+	// user changed their passphrase on a different device since we
+	// won't be able to sync the new server half. Double check before
+	// trying, since a stale "not connected" reading would otherwise
+	// fail this unlocker for no reason.
+	if u.G().ConnectivityMonitor.IsConnected(m.Ctx()) != ConnectivityMonitorYes {
+		m.CDebugf("PassphraseUnlocker: ConnectivityMonitor says not reachable, checking to make sure")
+		if err := u.G().ConnectivityMonitor.CheckReachability(m.Ctx()); err != nil {
+			m.CDebugf("PassphraseUnlocker: error checking reachability: %s", err)
+		}
+	}
+
+	return u.G().LoginState().GetPassphraseStreamLKSecFromPrompt(m, m.SecretKeyPromptArg(ska, reason))
+}
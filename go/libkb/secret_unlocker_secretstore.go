@@ -0,0 +1,45 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// SecretStoreUnlockerName is the UserConfig.PreferredUnlocker value that
+// selects SecretStoreUnlocker.
+const SecretStoreUnlockerName = "secret-store"
+
+// SecretStoreUnlocker unlocks a device key using the secret cached in
+// the OS-provided secret store (keychain, libsecret, Windows Credential
+// Manager) by the existing SecretStore implementations, rather than
+// deriving LKSec fresh from a passphrase.
+type SecretStoreUnlocker struct {
+	Contextified
+}
+
+// NewSecretStoreUnlocker creates a SecretStoreUnlocker.
+func NewSecretStoreUnlocker(g *GlobalContext) *SecretStoreUnlocker {
+	return &SecretStoreUnlocker{Contextified: NewContextified(g)}
+}
+
+// Name implements SecretUnlocker.
+func (u *SecretStoreUnlocker) Name() string {
+	return SecretStoreUnlockerName
+}
+
+// Unlock implements SecretUnlocker by pulling the cached secret straight
+// out of the OS secret store for ska.Me, with no UI prompt at all. If
+// nothing is cached, it reports ErrUnlockerUnavailable so the caller
+// falls through to the next unlocker.
+func (u *SecretStoreUnlocker) Unlock(m MetaContext, ska SecretKeyArg, reason string) (LKSecFullSecret, error) {
+	store := NewSecretStore(u.G(), ska.Me.GetNormalizedName())
+	if store == nil {
+		return LKSecFullSecret{}, ErrUnlockerUnavailable
+	}
+
+	secret, err := store.RetrieveSecret()
+	if err != nil {
+		m.CDebugf("SecretStoreUnlocker: RetrieveSecret failed: %s", err)
+		return LKSecFullSecret{}, ErrUnlockerUnavailable
+	}
+
+	return NewLKSecFullSecretFromBytes(secret.Bytes())
+}
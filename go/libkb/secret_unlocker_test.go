@@ -0,0 +1,83 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUnlocker struct {
+	name   string
+	secret LKSecFullSecret
+	err    error
+}
+
+func (f fakeUnlocker) Name() string { return f.name }
+
+func (f fakeUnlocker) Unlock(m MetaContext, ska SecretKeyArg, reason string) (LKSecFullSecret, error) {
+	return f.secret, f.err
+}
+
+func TestUnlockerChainFallsThroughUnavailable(t *testing.T) {
+	tc := SetupTest(t, "secret_unlocker", 1)
+	defer tc.Cleanup()
+
+	want := LKSecFullSecret{}
+	chain := UnlockerChain{
+		fakeUnlocker{name: "a", err: ErrUnlockerUnavailable},
+		fakeUnlocker{name: "b", secret: want, err: nil},
+	}
+
+	got, err := chain.Unlock(NewMetaContextForTest(tc), SecretKeyArg{}, "test")
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestUnlockerChainStopsOnRealError(t *testing.T) {
+	tc := SetupTest(t, "secret_unlocker", 1)
+	defer tc.Cleanup()
+
+	boom := errors.New("boom")
+	chain := UnlockerChain{
+		fakeUnlocker{name: "a", err: boom},
+		fakeUnlocker{name: "b", secret: LKSecFullSecret{}, err: nil},
+	}
+
+	_, err := chain.Unlock(NewMetaContextForTest(tc), SecretKeyArg{}, "test")
+	require.Equal(t, boom, err, "a real error from an earlier unlocker must not be masked by a later one succeeding")
+}
+
+func TestUnlockerChainAllUnavailable(t *testing.T) {
+	tc := SetupTest(t, "secret_unlocker", 1)
+	defer tc.Cleanup()
+
+	chain := UnlockerChain{
+		fakeUnlocker{name: "a", err: ErrUnlockerUnavailable},
+		fakeUnlocker{name: "b", err: ErrUnlockerUnavailable},
+	}
+
+	_, err := chain.Unlock(NewMetaContextForTest(tc), SecretKeyArg{}, "test")
+	require.Equal(t, ErrUnlockerUnavailable, err)
+}
+
+func TestUnlockerChainForUserPutsPreferredFirst(t *testing.T) {
+	tc := SetupTest(t, "secret_unlocker", 1)
+	defer tc.Cleanup()
+
+	config := &UserConfig{PreferredUnlocker: SecretStoreUnlockerName}
+	chain := UnlockerChainForUser(tc.G, config)
+	require.Equal(t, SecretStoreUnlockerName, chain[0].Name())
+	require.Len(t, chain, 3)
+}
+
+func TestUnlockerChainForUserNoPreference(t *testing.T) {
+	tc := SetupTest(t, "secret_unlocker", 1)
+	defer tc.Cleanup()
+
+	chain := UnlockerChainForUser(tc.G, nil)
+	require.Equal(t, PassphraseUnlockerName, chain[0].Name(), "with no preference recorded, passphrase/LKSec stays first")
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// passiveRefreshInterval is how often a passive session's UPAK and
+// device key are re-checked, so that promoting it to active via
+// PromoteToActive doesn't have to block on a reload first.
+const passiveRefreshInterval = 15 * time.Minute
+
+// CachedSession is the state SessionRegistry keeps for one provisioned
+// user, whether or not that user is the one currently driving the UI.
+type CachedSession struct {
+	UID      keybase1.UID
+	Username NormalizedUsername
+	DeviceID keybase1.DeviceID
+
+	mu        sync.Mutex
+	passive   bool
+	watchOnce sync.Once
+}
+
+// Passive reports whether this session is currently in the background:
+// cached, with notifications suppressed and no push subscriptions.
+func (cs *CachedSession) Passive() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.passive
+}
+
+func (cs *CachedSession) setPassive(passive bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.passive = passive
+}
+
+// SessionRegistry tracks every provisioned user the service is holding a
+// session for, keyed by UID. At most one registered session is active
+// (foreground) at a time; the rest are passive. This is what lets the
+// GUI hold several provisioned accounts open at once and flip between
+// them without re-entering a passphrase or tearing down chat state.
+type SessionRegistry struct {
+	Contextified
+
+	sync.Mutex
+	sessions  map[keybase1.UID]*CachedSession
+	activeUID keybase1.UID
+}
+
+// NewSessionRegistry creates an empty SessionRegistry.
+func NewSessionRegistry(g *GlobalContext) *SessionRegistry {
+	return &SessionRegistry{
+		Contextified: NewContextified(g),
+		sessions:     make(map[keybase1.UID]*CachedSession),
+	}
+}
+
+// Register adds uid to the registry if it isn't already present and
+// returns its CachedSession, honoring the caller's passive intent even
+// for the very first session: a freshly started service with only a
+// passive login outstanding should have no active session yet, rather
+// than one forced active behind the caller's back.
+func (r *SessionRegistry) Register(uid keybase1.UID, username NormalizedUsername, deviceID keybase1.DeviceID, passive bool) *CachedSession {
+	r.Lock()
+	cs, ok := r.sessions[uid]
+	if !ok {
+		cs = &CachedSession{UID: uid, Username: username, DeviceID: deviceID, passive: passive}
+		r.sessions[uid] = cs
+		if !passive {
+			r.activeUID = uid
+		}
+	}
+	r.Unlock()
+	if passive {
+		r.startWatching(cs)
+	}
+	return cs
+}
+
+// startWatching spawns watchPassive for cs the first time it's ever
+// needed (registered passive, or later demoted to passive), and never
+// again: an actively-registered session that's promoted to active and
+// never demoted should cost the process a goroutine and ticker exactly
+// once it actually has something to watch, not for the rest of its
+// lifetime just because it's sitting in the registry.
+func (r *SessionRegistry) startWatching(cs *CachedSession) {
+	cs.watchOnce.Do(func() {
+		go r.watchPassive(cs)
+	})
+}
+
+// Lookup finds the cached session for username, if one is registered.
+func (r *SessionRegistry) Lookup(username NormalizedUsername) *CachedSession {
+	r.Lock()
+	defer r.Unlock()
+	for _, cs := range r.sessions {
+		if cs.Username.Eq(username) {
+			return cs
+		}
+	}
+	return nil
+}
+
+// Active returns the currently active session, or nil if none is
+// registered yet.
+func (r *SessionRegistry) Active() *CachedSession {
+	r.Lock()
+	defer r.Unlock()
+	return r.sessions[r.activeUID]
+}
+
+// PromoteToActive makes uid the active session and demotes whatever was
+// previously active to passive. The registry's own lock serializes this,
+// so two passive sessions racing to become active can't both win.
+func (r *SessionRegistry) PromoteToActive(uid keybase1.UID) {
+	r.Lock()
+	defer r.Unlock()
+	r.promoteLocked(uid)
+}
+
+// PromoteToActiveWithHandoff promotes uid the same way PromoteToActive
+// does, but keeps the registry locked for the duration of handoff, so
+// handing ActiveDevice, LoginState, and chat/gregor ownership to uid
+// happens atomically with the registry flipping its active/passive bits
+// instead of racing a concurrent promotion of a different user. If
+// handoff returns an error, the registry's own promotion is rolled back
+// before PromoteToActiveWithHandoff returns; undoing anything handoff
+// already changed outside the registry is left to handoff itself.
+func (r *SessionRegistry) PromoteToActiveWithHandoff(uid keybase1.UID, handoff func(previous *CachedSession) error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	prevUID := r.activeUID
+	prev := r.sessions[prevUID]
+
+	r.promoteLocked(uid)
+
+	if err := handoff(prev); err != nil {
+		r.promoteLocked(prevUID)
+		return err
+	}
+	return nil
+}
+
+// promoteLocked does the actual bit-flipping for a promotion; callers
+// must hold r's lock. Any session left passive by this call starts
+// being watched, including one that was active a moment ago and is only
+// now passive for the first time.
+func (r *SessionRegistry) promoteLocked(uid keybase1.UID) {
+	for u, cs := range r.sessions {
+		cs.setPassive(u != uid)
+		if u != uid {
+			r.startWatching(cs)
+		}
+	}
+	r.activeUID = uid
+}
+
+// watchPassive keeps a passive session's UPAK and device key fresh on a
+// slow ticker, so that failover to it is instant rather than blocking on
+// a reload. It does no work while the session is active. A session
+// whose device turns out to be revoked or deleted is dropped from the
+// registry outright, the same way loadMe's nukeDevice path would have
+// caught it on a foreground login, so PromoteToActive can't hand control
+// to it afterwards. A session that goes bad between two ticks stays in
+// the registry until the next tick.
+func (r *SessionRegistry) watchPassive(cs *CachedSession) {
+	ticker := time.NewTicker(passiveRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !cs.Passive() {
+			continue
+		}
+		m := NewMetaContext(context.Background(), r.G())
+		arg := NewLoadUserArgWithMetaContext(m).WithUID(cs.UID).WithForcePoll(true).WithPublicKeyOptional()
+		upak, _, err := r.G().GetUPAKLoader().LoadV2(arg)
+		if err != nil {
+			m.CDebugf("SessionRegistry: passive refresh of %s failed: %s", cs.Username, err)
+			continue
+		}
+		if upak.Current.Status == keybase1.StatusCode_SCDeleted {
+			m.CDebugf("SessionRegistry: passive session %s was deleted, dropping it", cs.Username)
+			r.drop(cs.UID)
+			return
+		}
+		device := upak.Current.FindSigningDeviceKey(cs.DeviceID)
+		if device == nil || device.Base.Revocation != nil {
+			m.CDebugf("SessionRegistry: device %s for passive session %s is no longer valid, dropping it", cs.DeviceID, cs.Username)
+			r.drop(cs.UID)
+			return
+		}
+	}
+}
+
+// drop removes uid from the registry, used when a passive session's
+// background refresh finds it's no longer valid.
+func (r *SessionRegistry) drop(uid keybase1.UID) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.sessions, uid)
+	if r.activeUID.Equal(uid) {
+		r.activeUID = ""
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRegistryRegisterRespectsPassive(t *testing.T) {
+	tc := SetupTest(t, "session_registry", 1)
+	defer tc.Cleanup()
+
+	r := NewSessionRegistry(tc.G)
+	uid := keybase1.UID("0123456789abcdef0123456789abcdef")
+
+	cs := r.Register(uid, NewNormalizedUsername("alice"), keybase1.DeviceID(""), true /* passive */)
+	require.True(t, cs.Passive(), "a session registered passive should stay passive, even as the first one in")
+	require.Nil(t, r.Active(), "no session is active yet")
+}
+
+func TestSessionRegistryRegisterFirstActive(t *testing.T) {
+	tc := SetupTest(t, "session_registry", 1)
+	defer tc.Cleanup()
+
+	r := NewSessionRegistry(tc.G)
+	uid := keybase1.UID("0123456789abcdef0123456789abcdef")
+
+	cs := r.Register(uid, NewNormalizedUsername("alice"), keybase1.DeviceID(""), false)
+	require.False(t, cs.Passive())
+	require.NotNil(t, r.Active())
+	require.True(t, r.Active().UID.Equal(uid))
+}
+
+func TestSessionRegistryPromoteToActiveIsExclusive(t *testing.T) {
+	tc := SetupTest(t, "session_registry", 1)
+	defer tc.Cleanup()
+
+	r := NewSessionRegistry(tc.G)
+	uidA := keybase1.UID("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	uidB := keybase1.UID("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	r.Register(uidA, NewNormalizedUsername("alice"), keybase1.DeviceID(""), false)
+	r.Register(uidB, NewNormalizedUsername("bob"), keybase1.DeviceID(""), true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); r.PromoteToActive(uidA) }()
+		go func() { defer wg.Done(); r.PromoteToActive(uidB) }()
+	}
+	wg.Wait()
+
+	active := r.Active()
+	require.NotNil(t, active)
+
+	var passive *CachedSession
+	if active.UID.Equal(uidA) {
+		passive = r.Lookup(NewNormalizedUsername("bob"))
+	} else {
+		passive = r.Lookup(NewNormalizedUsername("alice"))
+	}
+	require.False(t, active.Passive(), "the active session is never left marked passive")
+	require.True(t, passive.Passive(), "exactly one session is active at a time")
+}
+
+func TestSessionRegistryPromoteToActiveWithHandoffRollsBackOnError(t *testing.T) {
+	tc := SetupTest(t, "session_registry", 1)
+	defer tc.Cleanup()
+
+	r := NewSessionRegistry(tc.G)
+	uidA := keybase1.UID("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	uidB := keybase1.UID("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	r.Register(uidA, NewNormalizedUsername("alice"), keybase1.DeviceID(""), false)
+	r.Register(uidB, NewNormalizedUsername("bob"), keybase1.DeviceID(""), true)
+
+	boom := errors.New("boom")
+	err := r.PromoteToActiveWithHandoff(uidB, func(previous *CachedSession) error {
+		require.NotNil(t, previous, "the handoff should see alice as the outgoing active session")
+		require.True(t, previous.UID.Equal(uidA))
+		return boom
+	})
+	require.Equal(t, boom, err)
+
+	active := r.Active()
+	require.NotNil(t, active)
+	require.True(t, active.UID.Equal(uidA), "a failed handoff must leave the previous session active")
+	require.False(t, r.Lookup(NewNormalizedUsername("alice")).Passive())
+	require.True(t, r.Lookup(NewNormalizedUsername("bob")).Passive())
+}
+
+func TestSessionRegistryPromoteToActiveWithHandoffCommitsOnSuccess(t *testing.T) {
+	tc := SetupTest(t, "session_registry", 1)
+	defer tc.Cleanup()
+
+	r := NewSessionRegistry(tc.G)
+	uidA := keybase1.UID("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	uidB := keybase1.UID("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	r.Register(uidA, NewNormalizedUsername("alice"), keybase1.DeviceID(""), false)
+	r.Register(uidB, NewNormalizedUsername("bob"), keybase1.DeviceID(""), true)
+
+	var handedOff keybase1.UID
+	err := r.PromoteToActiveWithHandoff(uidB, func(previous *CachedSession) error {
+		handedOff = previous.UID
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, handedOff.Equal(uidA))
+
+	active := r.Active()
+	require.NotNil(t, active)
+	require.True(t, active.UID.Equal(uidB))
+}
@@ -0,0 +1,35 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package libkb
+
+// GetPreferredUnlocker returns the name of the SecretUnlocker this user
+// asked to be tried first (see UserConfig.PreferredUnlocker), or "" if
+// none was recorded, in which case UnlockerChainForUser falls back to
+// the default order.
+func (u *UserConfig) GetPreferredUnlocker() string {
+	if u == nil {
+		return ""
+	}
+	return u.PreferredUnlocker
+}
+
+// WritePreferredUnlocker records name as username's preferred
+// SecretUnlocker and persists it to the config file, the way a device
+// provisioning ceremony would record the unlocker the user picked at
+// provision time. This tree has no such ceremony engine to call it from
+// directly, so LoginProvisionedDevice calls it the first time a login
+// succeeds with no preference recorded yet, passing the name of the
+// first unlocker in the chain it used. It is not told which unlocker in
+// that chain produced the secret.
+func WritePreferredUnlocker(m MetaContext, username NormalizedUsername, name string) error {
+	config, err := m.G().Env.GetConfig().GetUserConfigForUsername(username)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	config.PreferredUnlocker = name
+	return m.G().Env.GetConfigWriter().SetUserConfig(config, false)
+}
@@ -0,0 +1,17 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package keybase1
+
+// LoginRetryArg is the payload of the notification NotifyRouter sends
+// each time a stored-secret login attempt fails transiently and is
+// about to be retried, so the GUI can show retry progress instead of a
+// hard failure.
+type LoginRetryArg struct {
+	// Attempt is the 1-indexed number of the attempt that just failed.
+	Attempt int
+
+	// DelayMsec is how long the client will wait before the next
+	// attempt, at most; connectivity being restored cuts the wait short.
+	DelayMsec int64
+}